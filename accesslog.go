@@ -0,0 +1,121 @@
+// Copyright (c) 2025 Christopher Milan.
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN ACTION
+// OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+  "bufio"
+  "encoding/json"
+  "fmt"
+  "io"
+  "log"
+  "net"
+  "net/http"
+  "time"
+)
+
+// accessLogEntry is one proxied request, in whichever of -log-format's two
+// shapes gets written out.
+type accessLogEntry struct {
+  Time     time.Time     `json:"time"`
+  ClientIP string        `json:"client_ip"`
+  Method   string        `json:"method"`
+  Host     string        `json:"host"`
+  Path     string        `json:"path"`
+  Status   int           `json:"status"`
+  Bytes    int64         `json:"bytes"`
+  Upstream string        `json:"upstream"`
+  Latency  time.Duration `json:"latency"`
+}
+
+func logAccess(e accessLogEntry) {
+  if *logFormat == "json" {
+    data, err := json.Marshal(e)
+    if err != nil {
+      log.Printf("ERR: could not marshal access log entry: %v", err)
+      return
+    }
+    log.Print(string(data))
+    return
+  }
+
+  upstream := e.Upstream
+  if upstream == "" {
+    upstream = "-"
+  }
+  clientIP := e.ClientIP
+  if clientIP == "" {
+    clientIP = "-"
+  }
+  log.Printf("%s - - [%s] %q %d %d %q %s",
+    clientIP,
+    e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+    fmt.Sprintf("%s %s HTTP/1.1", e.Method, e.Path),
+    e.Status, e.Bytes, upstream, e.Latency)
+}
+
+// statusWriter wraps an http.ResponseWriter to capture the status code and
+// byte count that ultimately went out, for the access log and metrics.
+type statusWriter struct {
+  http.ResponseWriter
+  status int
+  bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+  w.status = status
+  w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+  if w.status == 0 {
+    w.status = http.StatusOK
+  }
+  n, err := w.ResponseWriter.Write(b)
+  w.bytes += int64(n)
+  return n, err
+}
+
+// Hijack, Flush, and ReadFrom pass through to the underlying ResponseWriter
+// when it supports them. httputil.ReverseProxy type-asserts the writer it's
+// given directly, so without these statusWriter would silently break
+// WebSocket upgrades (Hijack) and incremental flushing of streamed/SSE
+// responses (Flush).
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+  hj, ok := w.ResponseWriter.(http.Hijacker)
+  if !ok {
+    return nil, nil, http.ErrNotSupported
+  }
+  return hj.Hijack()
+}
+
+func (w *statusWriter) Flush() {
+  if f, ok := w.ResponseWriter.(http.Flusher); ok {
+    f.Flush()
+  }
+}
+
+func (w *statusWriter) ReadFrom(r io.Reader) (int64, error) {
+  if w.status == 0 {
+    w.status = http.StatusOK
+  }
+  rf, ok := w.ResponseWriter.(io.ReaderFrom)
+  if !ok {
+    n, err := io.Copy(w.ResponseWriter, r)
+    w.bytes += n
+    return n, err
+  }
+  n, err := rf.ReadFrom(r)
+  w.bytes += n
+  return n, err
+}
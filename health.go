@@ -0,0 +1,89 @@
+// Copyright (c) 2025 Christopher Milan.
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN ACTION
+// OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+  "context"
+  "log"
+  "net/http"
+  "net/url"
+  "time"
+)
+
+// startHealthChecks launches one monitoring goroutine per distinct backend
+// referenced by routes; each stops when ctx is cancelled.
+func startHealthChecks(ctx context.Context, routes []route) {
+  seen := make(map[*backend]bool)
+  for _, rt := range routes {
+    for _, b := range rt.backends {
+      if seen[b] {
+        continue
+      }
+      seen[b] = true
+      go monitorBackend(ctx, b)
+    }
+  }
+}
+
+// monitorBackend periodically probes b's health endpoint, flipping b.healthy
+// once -health-unhealthy-threshold consecutive failures or
+// -health-healthy-threshold consecutive successes are observed.
+func monitorBackend(ctx context.Context, b *backend) {
+  client := &http.Client{Timeout: *healthTimeout}
+  ticker := time.NewTicker(*healthInterval)
+  defer ticker.Stop()
+
+  var consecutive int // positive run of successes, negative run of failures
+
+  for {
+    select {
+    case <-ctx.Done():
+      return
+    case <-ticker.C:
+      if probeBackend(client, b.url) {
+        if consecutive < 0 {
+          consecutive = 0
+        }
+        consecutive++
+        if consecutive >= *healthHealthy && !b.healthy.Load() {
+          log.Printf("INFO: backend %s is healthy", b.url.String())
+          b.healthy.Store(true)
+        }
+      } else {
+        if consecutive > 0 {
+          consecutive = 0
+        }
+        consecutive--
+        if -consecutive >= *healthUnhealthy && b.healthy.Load() {
+          log.Printf("WARN: backend %s is unhealthy", b.url.String())
+          b.healthy.Store(false)
+        }
+      }
+    }
+  }
+}
+
+func probeBackend(client *http.Client, backendURL url.URL) bool {
+  u := backendURL
+  u.Path = *healthPath
+  u.RawQuery = ""
+
+  resp, err := client.Get(u.String())
+  if err != nil {
+    return false
+  }
+  defer resp.Body.Close()
+
+  return resp.StatusCode < http.StatusInternalServerError
+}
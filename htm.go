@@ -15,14 +15,23 @@ package main
 
 import (
   "bufio"
+  "context"
   "flag"
   "fmt"
   "log"
+  "math/rand"
+  "net"
   "net/http"
   "net/http/httputil"
   "net/url"
   "os"
+  "strconv"
   "strings"
+  "sync/atomic"
+  "time"
+
+  "github.com/pires/go-proxyproto"
+  "golang.org/x/crypto/acme/autocert"
 )
 
 func usage() {
@@ -32,14 +41,149 @@ func usage() {
 }
 
 var (
-  port       = flag.Int("port", 8080, "address to serve on")
-  configPath = flag.String("config", "/etc/htm/htm.conf", "configuration file")
+  port        = flag.Int("port", 8080, "address to serve on")
+  configPath  = flag.String("config", "/etc/htm/htm.conf", "configuration file")
+  httpsPort   = flag.Int("https-port", 0, "address to serve HTTPS on via Let's Encrypt (0 disables TLS)")
+  acmeCache   = flag.String("acme-cache", "/var/cache/htm/acme", "directory to cache ACME account/certificate data in")
+  acmeEmail   = flag.String("acme-email", "", "contact email to register with Let's Encrypt")
+  acceptProxy = flag.Bool("accept-proxy", false, "accept PROXY protocol v1/v2 headers on inbound connections")
+  watchConfig = flag.Bool("watch-config", false, "reload -config automatically when it changes on disk (SIGHUP always reloads)")
+
+  healthPath      = flag.String("health-path", "/", "path to request when health-checking a backend")
+  healthInterval  = flag.Duration("health-interval", 10*time.Second, "interval between backend health checks")
+  healthTimeout   = flag.Duration("health-timeout", 2*time.Second, "timeout for a single backend health check")
+  healthUnhealthy = flag.Int("health-unhealthy-threshold", 3, "consecutive failed checks before a backend is marked unhealthy")
+  healthHealthy   = flag.Int("health-healthy-threshold", 2, "consecutive successful checks before an unhealthy backend is marked healthy again")
+  lbPolicy        = flag.String("lb-policy", "round-robin", "load-balancing policy across a host's healthy backends: round-robin, random, or least-conn")
+
+  logFormat   = flag.String("log-format", "clf", "access log format: clf or json")
+  metricsAddr = flag.String("metrics-addr", "", "address to serve Prometheus metrics on (empty disables the metrics endpoint)")
 )
 
-type HTMProxy map[string]url.URL
+// backend is a single upstream URL behind a target. Its health fields are
+// written only by the health checker goroutine and read (lock-free) by
+// ServeHTTP, so they're atomics rather than plain fields.
+type backend struct {
+  url     url.URL
+  healthy atomic.Bool
+  conns   atomic.Int64 // in-flight requests, for the least-conn policy
+}
 
-func newHTMProxy(filename string) (HTMProxy, error) {
-  result := make(map[string]url.URL)
+// target is everything htm knows about a single configured hostname: where
+// to send its traffic, and how that hostname participates in TLS.
+type target struct {
+  backends    []*backend
+  rr          *atomic.Uint64 // round-robin cursor, shared across requests
+  noTLS       bool           // exclude this host from the autocert allowlist
+  redirect    bool           // send plain-HTTP requests to https://
+  sendProxy   bool           // emit a PROXY protocol header when dialing this backend
+  stripPrefix bool           // remove the matched path prefix before proxying
+}
+
+// pick returns a healthy backend chosen according to -lb-policy, or false if
+// every backend behind this target is currently unhealthy.
+func (t target) pick() (*backend, bool) {
+  healthy := make([]*backend, 0, len(t.backends))
+  for _, b := range t.backends {
+    if b.healthy.Load() {
+      healthy = append(healthy, b)
+    }
+  }
+  if len(healthy) == 0 {
+    return nil, false
+  }
+
+  switch *lbPolicy {
+  case "random":
+    return healthy[rand.Intn(len(healthy))], true
+  case "least-conn":
+    best := healthy[0]
+    for _, b := range healthy[1:] {
+      if b.conns.Load() < best.conns.Load() {
+        best = b
+      }
+    }
+    return best, true
+  default: // round-robin
+    i := t.rr.Add(1) - 1
+    return healthy[i%uint64(len(healthy))], true
+  }
+}
+
+// route is a single `host[/path-prefix]` rule from htm.conf. path is "" for
+// a rule with no prefix, in which case it matches every path under host.
+type route struct {
+  host string
+  path string
+  target
+}
+
+// HTMProxy is the parsed, ready-to-serve form of htm.conf: the routes as
+// written, a trie built from them for fast hostname resolution, and the
+// health checkers backing each route's backends.
+type HTMProxy struct {
+  routes []route
+  trie   *hostTrie
+  cancel context.CancelFunc // stops this config's health checkers
+}
+
+// tlsHosts returns the distinct literal hostnames eligible for an ACME
+// certificate, i.e. every configured host that isn't a wildcard or catch-all
+// (autocert issues per-hostname via HTTP-01, which can't prove ownership of
+// a wildcard) and hasn't opted out with `!notls`.
+func (config *HTMProxy) tlsHosts() []string {
+  seen := make(map[string]bool)
+  hosts := make([]string, 0, len(config.routes))
+  for _, rt := range config.routes {
+    if rt.noTLS || strings.HasPrefix(rt.host, "*") || seen[rt.host] {
+      continue
+    }
+    seen[rt.host] = true
+    hosts = append(hosts, rt.host)
+  }
+  return hosts
+}
+
+// match resolves hostname to its most specific configured host (exact >
+// deepest wildcard > catch-all), then picks the route under that host whose
+// path prefixes path, preferring the longest matching path. hostname may
+// carry a ":port" suffix, as a request's Host header does; it's stripped
+// before lookup since routes are configured by hostname alone.
+func (config *HTMProxy) match(hostname, path string) (route, bool) {
+  if h, _, err := net.SplitHostPort(hostname); err == nil {
+    hostname = h
+  }
+
+  var best route
+  found := false
+
+  for _, rt := range config.trie.lookup(hostname) {
+    if !pathHasPrefix(path, rt.path) {
+      continue
+    }
+    if !found || len(rt.path) > len(best.path) {
+      best = rt
+      found = true
+    }
+  }
+
+  return best, found
+}
+
+// pathHasPrefix reports whether prefix matches path at a "/" boundary, so a
+// rule for "/v1" matches "/v1" and "/v1/admin" but not "/v1abc".
+func pathHasPrefix(path, prefix string) bool {
+  if prefix == "" {
+    return true
+  }
+  if !strings.HasPrefix(path, prefix) {
+    return false
+  }
+  return len(path) == len(prefix) || prefix[len(prefix)-1] == '/' || path[len(prefix)] == '/'
+}
+
+func newHTMProxy(filename string) (*HTMProxy, error) {
+  var routes []route
 
   file, err := os.Open(filename)
   if err != nil {
@@ -65,20 +209,62 @@ func newHTMProxy(filename string) (HTMProxy, error) {
       continue
     }
 
-    target, err := url.Parse(fields[0])
-    if err != nil || target.Scheme == "" || target.Host == "" {
-      log.Printf("WARN: Ignoring invalid line %s:%d (invalid url)", filename, lineNum)
+    var backends []*backend
+    for _, rawURL := range strings.Split(fields[0], ",") {
+      backendURL, err := url.Parse(rawURL)
+      if err != nil || backendURL.Scheme == "" || backendURL.Host == "" {
+        log.Printf("WARN: Ignoring invalid backend '%s' on %s:%d (invalid url)", rawURL, filename, lineNum)
+        continue
+      }
+      b := &backend{url: *backendURL}
+      b.healthy.Store(true) // assume healthy until the first check says otherwise
+      backends = append(backends, b)
+    }
+    if len(backends) == 0 {
+      log.Printf("WARN: Ignoring invalid line %s:%d (no valid backends)", filename, lineNum)
       continue
     }
 
-    for _, host := range fields[1:] {
-      if strings.HasPrefix(host, "#") {
+    for _, field := range fields[1:] {
+      if strings.HasPrefix(field, "#") {
         break
       }
-      if _, exists := result[host]; exists {
-        log.Printf("WARN: Hostname '%s' was assigned multiple ports, using %d", host, port)
+
+      hostPath := field
+      var opts []string
+      if idx := strings.Index(hostPath, "!"); idx >= 0 {
+        hostPath, opts = hostPath[:idx], strings.Split(hostPath[idx+1:], ",")
+      }
+
+      host, path := hostPath, ""
+      if idx := strings.Index(hostPath, "/"); idx >= 0 {
+        host, path = hostPath[:idx], hostPath[idx:]
+      }
+
+      t := target{backends: backends, rr: new(atomic.Uint64)}
+      for _, opt := range opts {
+        switch opt {
+        case "notls":
+          t.noTLS = true
+        case "redirect":
+          t.redirect = true
+        case "send-proxy":
+          t.sendProxy = true
+        case "strip-prefix":
+          t.stripPrefix = true
+        default:
+          log.Printf("WARN: Ignoring unknown option '%s' on %s:%d", opt, filename, lineNum)
+        }
       }
-      result[host] = *target
+
+      for i, rt := range routes {
+        if rt.host == host && rt.path == path {
+          log.Printf("WARN: '%s%s' was assigned multiple targets, using most recent", host, path)
+          routes = append(routes[:i], routes[i+1:]...)
+          break
+        }
+      }
+      routes = append(routes, route{host: host, path: path, target: t})
     }
   }
 
@@ -86,45 +272,220 @@ func newHTMProxy(filename string) (HTMProxy, error) {
     return nil, err
   }
 
-  return result, nil
+  ctx, cancel := context.WithCancel(context.Background())
+  startHealthChecks(ctx, routes)
+
+  return &HTMProxy{routes: routes, trie: newHostTrie(routes), cancel: cancel}, nil
 }
 
-func (config HTMProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+func (config *HTMProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  start := time.Now()
   hostname := r.Host
+  method, path := r.Method, r.URL.Path
+  sw := &statusWriter{ResponseWriter: w}
+  var upstream string
+  metricHost := "unmatched" // bounded sentinel; never label metrics by raw, client-controlled Host
 
-  var proxyUrl url.URL
-  found := false
+  clientIP := r.RemoteAddr
+  if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+    clientIP = ip
+  }
 
-  for host, url := range config {
-    if strings.HasSuffix(hostname, host) {
-      found = true
-      proxyUrl = url
-      break
+  defer func() {
+    status := sw.status
+    if status == 0 {
+      status = http.StatusOK
     }
-  }
+    logAccess(accessLogEntry{
+      Time:     start,
+      ClientIP: clientIP,
+      Method:   method,
+      Host:     hostname,
+      Path:     path,
+      Status:   status,
+      Bytes:    sw.bytes,
+      Upstream: upstream,
+      Latency:  time.Since(start),
+    })
+    requestsTotal.WithLabelValues(metricHost, strconv.Itoa(status)).Inc()
+  }()
 
+  rt, found := config.match(hostname, r.URL.Path)
   if !found {
-    http.Error(w, "Bad Gateway", http.StatusBadGateway)
-    log.Printf("WARN: failed to proxy request: %s", hostname)
+    http.Error(sw, "Bad Gateway", http.StatusBadGateway)
+    return
+  }
+  t := rt.target
+  metricHost = rt.host
+
+  if t.redirect && r.TLS == nil && *httpsPort > 0 {
+    http.Redirect(sw, r, redirectURL(r), http.StatusMovedPermanently)
     return
   }
 
-  log.Printf("INFO: proxying %s to %s", hostname, proxyUrl.String())
-  proxy := httputil.NewSingleHostReverseProxy(&proxyUrl)
-  proxy.ServeHTTP(w, r)
+  if t.stripPrefix && rt.path != "" {
+    r.URL.Path = strings.TrimPrefix(r.URL.Path, rt.path)
+    if !strings.HasPrefix(r.URL.Path, "/") {
+      r.URL.Path = "/" + r.URL.Path
+    }
+  }
+
+  // X-Forwarded-For is left to httputil.ReverseProxy, which appends the
+  // client IP derived from r.RemoteAddr itself; setting it here too would
+  // duplicate that entry.
+  r.Header.Set("X-Real-IP", clientIP)
+  if r.TLS != nil {
+    r.Header.Set("X-Forwarded-Proto", "https")
+  } else {
+    r.Header.Set("X-Forwarded-Proto", "http")
+  }
+
+  b, ok := t.pick()
+  if !ok {
+    http.Error(sw, "Service Unavailable", http.StatusServiceUnavailable)
+    return
+  }
+  upstream = b.url.String()
+
+  proxy := httputil.NewSingleHostReverseProxy(&b.url)
+  if t.sendProxy {
+    r = r.WithContext(context.WithValue(r.Context(), remoteAddrKey, r.RemoteAddr))
+    proxy.Transport = sendProxyTransport
+  }
+
+  b.conns.Add(1)
+  done := func() { b.conns.Add(-1) }
+  proxy.ModifyResponse = func(*http.Response) error {
+    done()
+    upstreamLatency.WithLabelValues(metricHost).Observe(time.Since(start).Seconds())
+    return nil
+  }
+  proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+    done()
+    http.Error(w, "Bad Gateway", http.StatusBadGateway)
+  }
+
+  proxy.ServeHTTP(sw, r)
+}
+
+// remoteAddrKey carries the inbound request's RemoteAddr through a request's
+// context so sendProxyDialContext can reach it despite running on a shared
+// *http.Transport rather than a per-request closure.
+type ctxKey int
+
+const remoteAddrKey ctxKey = 0
+
+// sendProxyTransport is shared by every send-proxy target instead of a fresh
+// *http.Transport being built per request. Keep-alives are disabled: the
+// PROXY header is written once per connection and names a single client, so
+// a connection is never reused for another request.
+var sendProxyTransport = &http.Transport{DialContext: sendProxyDialContext, DisableKeepAlives: true}
+
+// sendProxyDialContext dials the backend normally, then writes a PROXY
+// protocol v2 header carrying the real client address before any HTTP bytes
+// go out, so a downstream that also speaks PROXY protocol sees the original
+// client rather than htm itself.
+func sendProxyDialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+  conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+  if err != nil {
+    return nil, err
+  }
+
+  remoteAddr, _ := ctx.Value(remoteAddrKey).(string)
+  srcAddr, err := net.ResolveTCPAddr(network, remoteAddr)
+  if err != nil {
+    conn.Close()
+    return nil, err
+  }
+
+  header := proxyproto.HeaderProxyFromAddrs(2, srcAddr, conn.RemoteAddr())
+  if _, err := header.WriteTo(conn); err != nil {
+    conn.Close()
+    return nil, err
+  }
+
+  return conn, nil
+}
+
+// redirectURL rewrites r's URL to the https:// equivalent, swapping in
+// httpsPort unless it's the default HTTPS port.
+func redirectURL(r *http.Request) string {
+  host := r.Host
+  if h, _, err := net.SplitHostPort(host); err == nil {
+    host = h
+  }
+  if *httpsPort != 443 {
+    host = fmt.Sprintf("%s:%d", host, *httpsPort)
+  }
+
+  u := *r.URL
+  u.Scheme = "https"
+  u.Host = host
+  return u.String()
 }
 
 func main() {
   flag.Usage = usage
   flag.Parse()
 
+  if *metricsAddr != "" {
+    go serveMetrics(*metricsAddr)
+  }
+
   proxy, err := newHTMProxy(*configPath)
   if err != nil {
     log.Println("ERR: Could not read config:", err)
     return
   }
 
+  ph := &proxyHandler{}
+  ph.current.Store(proxy)
+  go ph.watch(*configPath, *watchConfig)
+
+  handler := http.Handler(ph)
+
+  if *httpsPort > 0 {
+    m := &autocert.Manager{
+      Prompt:     autocert.AcceptTOS,
+      HostPolicy: ph.hostPolicy,
+      Cache:      autocert.DirCache(*acmeCache),
+      Email:      *acmeEmail,
+    }
+    handler = m.HTTPHandler(ph)
+
+    go func() {
+      ln, err := listen(*httpsPort)
+      if err != nil {
+        log.Fatal("ERR: ", err)
+      }
+
+      server := &http.Server{Handler: ph, TLSConfig: m.TLSConfig()}
+      log.Printf("INFO: htm server started on :%d (https)", *httpsPort)
+      log.Fatal(fmt.Sprint("ERR: ", server.ServeTLS(ln, "", "")))
+    }()
+  }
+
+  ln, err := listen(*port)
+  if err != nil {
+    log.Fatal("ERR: ", err)
+  }
+
   log.Printf("INFO: htm server started on :%d", *port)
 
-  log.Fatal(fmt.Sprint("ERR: ", http.ListenAndServe(fmt.Sprint(":", *port), proxy)))
+  log.Fatal(fmt.Sprint("ERR: ", http.Serve(ln, handler)))
+}
+
+// listen opens a TCP listener on the given port, wrapping it to decode PROXY
+// protocol v1/v2 headers when -accept-proxy is set.
+func listen(port int) (net.Listener, error) {
+  ln, err := net.Listen("tcp", fmt.Sprint(":", port))
+  if err != nil {
+    return nil, err
+  }
+
+  if *acceptProxy {
+    ln = &proxyproto.Listener{Listener: ln}
+  }
+
+  return ln, nil
 }
@@ -0,0 +1,50 @@
+// Copyright (c) 2025 Christopher Milan.
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN ACTION
+// OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+  "fmt"
+  "log"
+  "net/http"
+
+  "github.com/prometheus/client_golang/prometheus"
+  "github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+  requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+    Name: "htm_requests_total",
+    Help: "Total requests served, by configured host (\"unmatched\" if none) and response status.",
+  }, []string{"host", "status"})
+
+  upstreamLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+    Name:    "htm_upstream_latency_seconds",
+    Help:    "Time from htm receiving a request to receiving the backend's response headers.",
+    Buckets: prometheus.DefBuckets,
+  }, []string{"host"})
+)
+
+func init() {
+  prometheus.MustRegister(requestsTotal, upstreamLatency)
+}
+
+// serveMetrics runs a Prometheus /metrics endpoint on addr. It never
+// returns; callers should run it in its own goroutine.
+func serveMetrics(addr string) {
+  mux := http.NewServeMux()
+  mux.Handle("/metrics", promhttp.Handler())
+
+  log.Printf("INFO: metrics server started on %s", addr)
+  log.Fatal(fmt.Sprint("ERR: ", http.ListenAndServe(addr, mux)))
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2025 Christopher Milan.
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN ACTION
+// OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+  "context"
+  "log"
+  "net/http"
+  "os"
+  "os/signal"
+  "path/filepath"
+  "sort"
+  "strings"
+  "sync/atomic"
+  "syscall"
+
+  "github.com/fsnotify/fsnotify"
+  "golang.org/x/crypto/acme/autocert"
+)
+
+// proxyHandler supervises a live HTMProxy, swapping it out for a freshly
+// parsed one on SIGHUP or (if enabled) when the config file changes on
+// disk, without ever dropping the listener or an in-flight request.
+type proxyHandler struct {
+  current atomic.Pointer[HTMProxy]
+}
+
+func (h *proxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  h.current.Load().ServeHTTP(w, r)
+}
+
+// hostPolicy is an autocert.HostPolicy that always consults the
+// currently-live config, so a reload takes effect on the ACME allowlist too.
+func (h *proxyHandler) hostPolicy(ctx context.Context, host string) error {
+  return autocert.HostWhitelist(h.current.Load().tlsHosts()...)(ctx, host)
+}
+
+// reload re-parses filename and atomically swaps it in as the live config.
+// A parse failure leaves the previous, known-good config in place.
+func (h *proxyHandler) reload(filename string) {
+  next, err := newHTMProxy(filename)
+  if err != nil {
+    log.Printf("ERR: failed to reload %s, keeping previous config: %v", filename, err)
+    return
+  }
+
+  old := h.current.Swap(next)
+  logHostDiff(old, next)
+  if old != nil {
+    old.cancel()
+  }
+}
+
+// watch blocks, reloading filename on every SIGHUP and, if watchFile is set,
+// every time filename changes on disk.
+func (h *proxyHandler) watch(filename string, watchFile bool) {
+  sighup := make(chan os.Signal, 1)
+  signal.Notify(sighup, syscall.SIGHUP)
+
+  var changed chan struct{}
+  if watchFile {
+    changed = h.watchFile(filename)
+  }
+
+  for {
+    select {
+    case <-sighup:
+      log.Printf("INFO: received SIGHUP, reloading %s", filename)
+      h.reload(filename)
+    case <-changed:
+      log.Printf("INFO: %s changed, reloading", filename)
+      h.reload(filename)
+    }
+  }
+}
+
+// watchFile sets up an fsnotify watch on filename's directory (watching the
+// file itself misses the remove-and-rename most editors use to save) and
+// returns a channel that fires whenever filename itself changes.
+func (h *proxyHandler) watchFile(filename string) chan struct{} {
+  changed := make(chan struct{})
+
+  watcher, err := fsnotify.NewWatcher()
+  if err != nil {
+    log.Printf("WARN: could not watch %s for changes: %v", filename, err)
+    return changed
+  }
+
+  if err := watcher.Add(filepath.Dir(filename)); err != nil {
+    log.Printf("WARN: could not watch %s for changes: %v", filename, err)
+    return changed
+  }
+
+  go func() {
+    for event := range watcher.Events {
+      if filepath.Clean(event.Name) == filepath.Clean(filename) {
+        changed <- struct{}{}
+      }
+    }
+  }()
+
+  return changed
+}
+
+// logHostDiff logs which hosts a reload added or removed, comparing old
+// (a nil old counts as having no hosts) against next.
+func logHostDiff(old, next *HTMProxy) {
+  added, removed := diffHosts(hostSet(old), hostSet(next))
+  if len(added) > 0 {
+    log.Printf("INFO: config reload added hosts: %s", strings.Join(added, ", "))
+  }
+  if len(removed) > 0 {
+    log.Printf("INFO: config reload removed hosts: %s", strings.Join(removed, ", "))
+  }
+}
+
+func hostSet(config *HTMProxy) map[string]bool {
+  set := make(map[string]bool)
+  if config == nil {
+    return set
+  }
+  for _, rt := range config.routes {
+    set[rt.host] = true
+  }
+  return set
+}
+
+func diffHosts(old, next map[string]bool) (added, removed []string) {
+  for host := range next {
+    if !old[host] {
+      added = append(added, host)
+    }
+  }
+  for host := range old {
+    if !next[host] {
+      removed = append(removed, host)
+    }
+  }
+  sort.Strings(added)
+  sort.Strings(removed)
+  return added, removed
+}
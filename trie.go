@@ -0,0 +1,102 @@
+// Copyright (c) 2025 Christopher Milan.
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN ACTION
+// OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import "strings"
+
+// hostNode is one label of a reverse-labels host trie: the path from the
+// root to a node spells out a hostname's labels from the TLD down.
+type hostNode struct {
+  children map[string]*hostNode
+  exact    []route // routes registered for this exact host
+  wildcard []route // routes registered for `*.` + this host
+}
+
+// hostTrie resolves a request hostname to the routes configured for it in
+// O(number of labels), independent of how many hosts are configured, and
+// without the cross-label false-positives a strings.HasSuffix scan allows
+// (a rule for "example.com" never matches "evilexample.com").
+type hostTrie struct {
+  root     *hostNode
+  catchAll []route // routes registered for "*"
+}
+
+func newHostTrie(routes []route) *hostTrie {
+  t := &hostTrie{root: &hostNode{children: make(map[string]*hostNode)}}
+  for _, rt := range routes {
+    switch {
+    case rt.host == "*":
+      t.catchAll = append(t.catchAll, rt)
+    case strings.HasPrefix(rt.host, "*."):
+      t.insert(rt.host[len("*."):], rt, true)
+    default:
+      t.insert(rt.host, rt, false)
+    }
+  }
+  return t
+}
+
+func (t *hostTrie) insert(host string, rt route, wildcard bool) {
+  node := t.root
+  for _, label := range reverseLabels(host) {
+    child, ok := node.children[label]
+    if !ok {
+      child = &hostNode{children: make(map[string]*hostNode)}
+      node.children[label] = child
+    }
+    node = child
+  }
+  if wildcard {
+    node.wildcard = append(node.wildcard, rt)
+  } else {
+    node.exact = append(node.exact, rt)
+  }
+}
+
+// lookup returns the most specific routes registered for hostname: an exact
+// match wins outright, otherwise the deepest matching "*." wildcard wins,
+// falling back to "*" if nothing else matches.
+func (t *hostTrie) lookup(hostname string) []route {
+  node := t.root
+  var bestWildcard []route
+
+  for _, label := range reverseLabels(hostname) {
+    if node == nil {
+      break
+    }
+    if node.wildcard != nil {
+      bestWildcard = node.wildcard
+    }
+    node = node.children[label]
+  }
+
+  if node != nil && node.exact != nil {
+    return node.exact
+  }
+  if bestWildcard != nil {
+    return bestWildcard
+  }
+  return t.catchAll
+}
+
+// reverseLabels splits host on "." and reverses the result, so that walking
+// the slice in order walks the trie from the TLD down to the most specific
+// label.
+func reverseLabels(host string) []string {
+  labels := strings.Split(host, ".")
+  for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+    labels[i], labels[j] = labels[j], labels[i]
+  }
+  return labels
+}
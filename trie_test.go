@@ -0,0 +1,102 @@
+package main
+
+import "testing"
+
+func routeFor(host string) route {
+  return route{host: host}
+}
+
+func TestHostTrieExactBeatsWildcard(t *testing.T) {
+  exact := routeFor("api.example.com")
+  wildcard := routeFor("*.example.com")
+  trie := newHostTrie([]route{exact, wildcard})
+
+  got := trie.lookup("api.example.com")
+  if len(got) != 1 || got[0].host != exact.host {
+    t.Fatalf("lookup(api.example.com) = %v, want exact match on %q", got, exact.host)
+  }
+}
+
+func TestHostTrieWildcardBeatsShallowerWildcard(t *testing.T) {
+  shallow := routeFor("*.example.com")
+  deep := routeFor("*.api.example.com")
+  trie := newHostTrie([]route{shallow, deep})
+
+  got := trie.lookup("v1.api.example.com")
+  if len(got) != 1 || got[0].host != deep.host {
+    t.Fatalf("lookup(v1.api.example.com) = %v, want deepest wildcard %q", got, deep.host)
+  }
+}
+
+func TestHostTrieWildcardMatchesAnyDepth(t *testing.T) {
+  wildcard := routeFor("*.example.com")
+  trie := newHostTrie([]route{wildcard})
+
+  got := trie.lookup("a.b.example.com")
+  if len(got) != 1 || got[0].host != wildcard.host {
+    t.Fatalf("lookup(a.b.example.com) = %v, want %q to match multi-level subdomain", got, wildcard.host)
+  }
+}
+
+func TestHostTrieCatchAllIsLastResort(t *testing.T) {
+  catchAll := routeFor("*")
+  wildcard := routeFor("*.example.com")
+  trie := newHostTrie([]route{catchAll, wildcard})
+
+  if got := trie.lookup("unrelated.net"); len(got) != 1 || got[0].host != catchAll.host {
+    t.Fatalf("lookup(unrelated.net) = %v, want catch-all %q", got, catchAll.host)
+  }
+  if got := trie.lookup("foo.example.com"); len(got) != 1 || got[0].host != wildcard.host {
+    t.Fatalf("lookup(foo.example.com) = %v, want wildcard %q to win over catch-all", got, wildcard.host)
+  }
+}
+
+func TestHostTrieRejectsCrossLabelSuffixMatch(t *testing.T) {
+  exact := routeFor("example.com")
+  trie := newHostTrie([]route{exact})
+
+  if got := trie.lookup("evilexample.com"); len(got) != 0 {
+    t.Fatalf("lookup(evilexample.com) = %v, want no match (cross-label false positive)", got)
+  }
+}
+
+func TestHTMProxyMatchPicksLongestPath(t *testing.T) {
+  config := &HTMProxy{
+    routes: []route{
+      {host: "example.com", path: ""},
+      {host: "example.com", path: "/v1"},
+      {host: "example.com", path: "/v1/admin"},
+    },
+  }
+  config.trie = newHostTrie(config.routes)
+
+  rt, found := config.match("example.com", "/v1/admin/users")
+  if !found || rt.path != "/v1/admin" {
+    t.Fatalf("match(/v1/admin/users) = %+v, found=%v, want path /v1/admin", rt, found)
+  }
+
+  rt, found = config.match("example.com", "/v1/other")
+  if !found || rt.path != "/v1" {
+    t.Fatalf("match(/v1/other) = %+v, found=%v, want path /v1", rt, found)
+  }
+
+  rt, found = config.match("example.com", "/")
+  if !found || rt.path != "" {
+    t.Fatalf("match(/) = %+v, found=%v, want the catch-all path route", rt, found)
+  }
+
+  rt, found = config.match("example.com", "/v1abc")
+  if !found || rt.path != "" {
+    t.Fatalf("match(/v1abc) = %+v, found=%v, want the catch-all path route, not /v1 (boundary)", rt, found)
+  }
+}
+
+func TestHTMProxyMatchStripsHostPort(t *testing.T) {
+  config := &HTMProxy{routes: []route{{host: "example.com"}}}
+  config.trie = newHostTrie(config.routes)
+
+  rt, found := config.match("example.com:8080", "/")
+  if !found || rt.host != "example.com" {
+    t.Fatalf("match(example.com:8080) = %+v, found=%v, want the example.com route", rt, found)
+  }
+}